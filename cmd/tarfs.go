@@ -11,7 +11,11 @@ import (
 
 func main() {
     var debug bool
+    var allowOther bool
+    var readWrite bool
     flag.BoolVar(&debug, "d", false, "Enable fuse debug mode")
+    flag.BoolVar(&allowOther, "allow-other", false, "Allow other users to access the mount")
+    flag.BoolVar(&readWrite, "rw", false, "Mount writable, keeping changes in an in-memory overlay")
 
     flag.Usage = usage
     flag.Parse()
@@ -23,12 +27,22 @@ func main() {
     archivePath := flag.Arg(0)
     mountpoint := flag.Arg(1)
 
-    if err := fs.MountAndServe(archivePath, mountpoint, debug); err != nil {
+    opts := fs.Options{
+        Debug: debug,
+        AllowOther: allowOther,
+    }
+
+    mount := fs.MountAndServe
+    if readWrite {
+        mount = fs.MountAndServeRW
+    }
+    if err := mount(archivePath, mountpoint, opts); err != nil {
         log.Fatal(err)
     }
 }
 
 func usage() {
-    fmt.Fprintln(os.Stderr, "Usage: tarfs [-d] ARCHIVE_PATH MOUNTPOINT")
+    fmt.Fprintln(os.Stderr, "Usage: tarfs [-d] [-allow-other] [-rw] ARCHIVE_PATH MOUNTPOINT")
+    fmt.Fprintln(os.Stderr, "  ARCHIVE_PATH may be a local path, an http(s):// URL, or \"-\" for stdin")
     flag.PrintDefaults()
 }
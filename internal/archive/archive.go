@@ -1,217 +1,163 @@
 package archive
 
 import (
-    "archive/tar"
-    "compress/bzip2"
-    "compress/gzip"
     "fmt"
     "io"
     "os"
-    "path"
-    "strings"
-    "time"
-
-    "github.com/DataDog/zstd"
+    "sync"
 )
 
-const (
-    errorUnsupportedFormat string = "Unsupported archive format"
-    compressionNone string = "none"
-    compressionBzip2 string = "bzip2"
-    compressionGzip string = "gzip"
-    compressionZstd string = "zstd"
-)
-
-type Node struct {
-    index int
-    Name string
-    FullName string
-    LinkName string
-    Size int64
-    Mode os.FileMode
-    typeflag byte
-    Uid int
-    Gid int
-    Mtime time.Time
-    Atime time.Time
-    Ctime time.Time
-    Parent *Node
-    Children []Node
-    Archive *Archive
-}
+const errorUnsupportedFormat string = "unsupported archive format"
 
 type Archive struct {
     Path string
     Nodes []Node
-    compression string
-}
+    backend Backend
 
-type tarEntry struct {
-    Index int
-    Header *tar.Header
-    Harvested bool
-}
+    // sourceCleanup removes the temp file materialize spooled a remote or
+    // stdin Source into, if any; it's a no-op for a plain local path.
+    sourceCleanup func()
 
-func (arch *Archive) Read(file *os.File) (*tar.Reader, error) {
-    file.Seek(0, 0)
-    var reader io.Reader
-    switch arch.compression {
-    case compressionBzip2:
-        reader = bzip2.NewReader(file)
-    case compressionGzip:
-        reader, _ = gzip.NewReader(file)
-    case compressionZstd:
-        reader = zstd.NewReader(file)
-    case compressionNone:
-        reader = file
-    default:
-        return nil, fmt.Errorf(errorUnsupportedFormat)
-    }
-
-    return tar.NewReader(reader), nil
+    // fsOnce builds root and pathIndex on first use by the io/fs.FS
+    // implementation in fsys.go; ReadArchive callers that never touch that
+    // API pay nothing for it.
+    fsOnce sync.Once
+    root *Node
+    pathIndex map[string]*Node
 }
 
+// ReadArchive resolves path with NewSource (a local path, an http(s) URL, or
+// "-" for stdin), probes the registered backends (tar, zip, ...) to find one
+// that understands it, and builds the Node tree from what it enumerates.
 func ReadArchive(path string) (*Archive, error) {
-    file, err := os.Open(path)
-    if err != nil {
-        return nil, err
+    return ReadSource(NewSource(path))
+}
+
+// ReadSource is ReadArchive for an already-built Source.
+//
+// Every Backend needs random access to its bytes, which an http response
+// body or stdin can't offer directly, so a non-local Source is normally
+// materialized into a local temp file first. A ReaderAtSource gets one
+// chance to skip that: tryRangedTar enumerates and serves entries straight
+// off its ranged reads when the remote archive turns out to be an
+// uncompressed tar, so a huge remote archive is never downloaded just to
+// read one file out of it. Anything tryRangedTar declines (compressed,
+// not a tar, range requests failing) falls back to the full materialize.
+func ReadSource(source Source) (*Archive, error) {
+    if ra, ok := source.(ReaderAtSource); ok {
+        if arch, ok := tryRangedTar(ra); ok {
+            return arch, nil
+        }
     }
-    defer file.Close()
-
-    arch := &Archive{Path: path}
 
-    if isBzip2(file) {
-        arch.compression = compressionBzip2
-    } else if isGzip(file) {
-        arch.compression = compressionGzip
-    } else if isZstd(file) {
-        arch.compression = compressionZstd
-    } else {
-        arch.compression = compressionNone
+    path, cleanup, err := materialize(source)
+    if err != nil {
+        return nil, err
     }
 
-    var entries []*tarEntry
+    probeFile, err := os.Open(path)
     if err != nil {
+        cleanup()
         return nil, err
     }
 
-    tarReader, err := arch.Read(file)
-    index := 0
-    for {
-        header, err := tarReader.Next()
-        if err == io.EOF {
+    var backend Backend
+    for _, reg := range backends {
+        if reg.probe(probeFile) {
+            probeFile.Close()
+
+            backend, err = reg.open(path)
+            if err != nil {
+                cleanup()
+                return nil, err
+            }
             break
-        } else if err == tar.ErrHeader {
-            return nil, fmt.Errorf(errorUnsupportedFormat)
-        } else if err != nil {
-            return nil, err
         }
+    }
+    if backend == nil {
+        probeFile.Close()
+        cleanup()
+        return nil, fmt.Errorf(errorUnsupportedFormat)
+    }
 
-        entries = append(entries, &tarEntry{Index: index, Header: header, Harvested: false})
-        index++
+    entries, err := backend.Enumerate()
+    if err != nil {
+        backend.Close()
+        cleanup()
+        return nil, err
     }
 
-    arch.Nodes = parseNodes(nil, entries, arch)
+    arch := &Archive{Path: path, backend: backend, sourceCleanup: cleanup}
+    arch.Nodes = buildTree(entries, arch)
     return arch, nil
 }
 
-func isBzip2(file *os.File) bool {
-    file.Seek(0, 0)
-    reader := bzip2.NewReader(file)
-    buf := make([]byte, 16)
-    _, err := reader.Read(buf)
-    return err == nil
-}
-
-func isGzip(file *os.File) bool {
-    file.Seek(0, 0)
-    _, err := gzip.NewReader(file)
-    return err == nil
-}
-
-func isZstd(file *os.File) bool {
-    file.Seek(0, 0)
-    reader := zstd.NewReader(file)
-    buf := make([]byte, 16)
-    _, err := reader.Read(buf)
-    return err == nil
-}
-
-func parseNodes(parent *Node, entries []*tarEntry, arch *Archive) []Node {
-    var nodes []Node
-    parentReached := false
-    for index, entry := range entries {
-        file := entry.Header.Name
-        isDir := entry.Header.FileInfo().IsDir()
-
-        file = strings.TrimPrefix(file, "/")
-        file = strings.TrimPrefix(file, "./")
-        if file == "" {
-            continue
-        }
-
-        // fast forward to current parent
-        if parent != nil && !parentReached {
-            if file == parent.FullName {
-                parentReached = true
-            }
-            continue
-        }
-
-        // exit if all childs of parent have been recursively harvested
-        if parent != nil && parentReached && !strings.HasPrefix(file, parent.FullName) {
-            break
-        }
+// tryRangedTar attempts the ranged-GET fast path for a ReaderAtSource: if
+// its content isn't an uncompressed tar, or anything about probing/
+// enumerating it over ranged reads fails, ok is false and the caller should
+// fall back to materialize instead. There's no partial state to clean up
+// either way since nothing is spooled to disk until that fallback runs.
+func tryRangedTar(source ReaderAtSource) (arch *Archive, ok bool) {
+    ra, size, err := source.ReaderAt()
+    if err != nil {
+        return nil, false
+    }
 
-        if parent == nil && !isDir && strings.Count(file, "/") > 0 {
-            continue
-        }
+    header := make([]byte, 6)
+    n, err := ra.ReadAt(header, 0)
+    if err != nil && err != io.EOF {
+        return nil, false
+    }
+    if detectCompression(header[:n]) != compressionNone {
+        return nil, false
+    }
 
-        if entry.Harvested {
-            continue
-        }
+    backend := openTarRanged(ra, size)
+    entries, err := backend.Enumerate()
+    if err != nil {
+        return nil, false
+    }
 
-        entry.Harvested = true
-        node := Node{
-            index: entry.Index,
-            Name: path.Base(file),
-            FullName: file,
-            LinkName: entry.Header.Linkname,
-            Size: entry.Header.Size,
-            Uid: entry.Header.Uid,
-            Gid: entry.Header.Gid,
-            Mode: entry.Header.FileInfo().Mode(),
-            typeflag: entry.Header.Typeflag,
-            Mtime: entry.Header.ModTime,
-            Atime: entry.Header.AccessTime,
-            Ctime: entry.Header.ChangeTime,
-            Archive: arch,
-            Parent: parent,
-        }
+    arch = &Archive{backend: backend, sourceCleanup: func() {}}
+    arch.Nodes = buildTree(entries, arch)
+    return arch, true
+}
 
-        if isDir {
-            node.Children = parseNodes(&node, entries[index:], arch)
-        }
+// materialize returns a local, seekable path for source: the path itself
+// for a local source, or a spooled copy of source's full content for
+// anything that isn't already a local file (stdin, http(s)). The returned
+// cleanup removes that spooled copy; it's a no-op for a local source.
+func materialize(source Source) (path string, cleanup func(), err error) {
+    if local, ok := source.(localSource); ok {
+        return local.path, func() {}, nil
+    }
 
-        nodes = append(nodes, node)
+    reader, err := source.Open()
+    if err != nil {
+        return "", nil, err
     }
+    defer reader.Close()
 
-    return nodes
-}
+    spool, err := os.CreateTemp("", "tarfs-source-*")
+    if err != nil {
+        return "", nil, err
+    }
+    defer spool.Close()
 
-func (node *Node) listRecursive(nodes *[]*Node) {
-    *nodes = append(*nodes, node)
-    for _, child := range node.Children {
-        child.listRecursive(nodes)
+    if _, err := io.Copy(spool, reader); err != nil {
+        os.Remove(spool.Name())
+        return "", nil, err
     }
-}
 
-func (node *Node) IsLink() bool {
-    return node.typeflag == tar.TypeLink
+    return spool.Name(), func() { os.Remove(spool.Name()) }, nil
 }
 
-func (node *Node) IsSymlink() bool {
-    return node.typeflag == tar.TypeSymlink
+func (arch *Archive) Close() error {
+    err := arch.backend.Close()
+    if arch.sourceCleanup != nil {
+        arch.sourceCleanup()
+    }
+    return err
 }
 
 func (arch *Archive) List() []*Node {
@@ -221,40 +167,3 @@ func (arch *Archive) List() []*Node {
     }
     return nodes
 }
-
-type NodeReader struct {
-    file *os.File
-    reader *tar.Reader
-}
-
-func (nodeReader *NodeReader) Read(buf []byte) (int, error) {
-    return nodeReader.reader.Read(buf)
-}
-
-func (nodeReader *NodeReader) Close() error {
-    return nodeReader.file.Close()
-}
-
-func (node *Node) Open() (io.ReadCloser, error) {
-    if !node.Mode.IsRegular() {
-        return nil, fmt.Errorf("Not a file")
-    }
-
-    file, err := os.Open(node.Archive.Path)
-    if err != nil {
-        return nil, err
-    }
-
-    reader, err := node.Archive.Read(file)
-    if err != nil {
-        return nil, err
-    }
-
-    for i := 0; i <= node.index; i++ {
-        if _, err := reader.Next(); err != nil {
-            return nil, err
-        }
-    }
-
-    return &NodeReader{file: file, reader: reader}, nil
-}
@@ -0,0 +1,171 @@
+package archive
+
+import (
+    "io"
+    "os"
+    "sync"
+    "time"
+)
+
+// EntryType is a backend-agnostic classification of an archive member,
+// independent of any one archive format's own type byte/flag.
+type EntryType int
+
+const (
+    TypeReg EntryType = iota
+    TypeDir
+    TypeSymlink
+    TypeLink
+    TypeChar
+    TypeBlock
+    TypeFifo
+)
+
+// Entry is a Backend's view of one archive member, before it's been linked
+// into the Node tree.
+type Entry struct {
+    Name string
+    LinkName string
+    Size int64
+    Mode os.FileMode
+    Type EntryType
+    Uid int
+    Gid int
+
+    // Uname and Gname are the string owner/group names tar's USTAR/PAX
+    // headers carry alongside the numeric Uid/Gid; zip has no equivalent
+    // and leaves these empty.
+    Uname string
+    Gname string
+
+    Mtime time.Time
+    Atime time.Time
+    Ctime time.Time
+
+    // Rdev is the device number for TypeChar/TypeBlock entries, encoded the
+    // same way as syscall.Mkdev/Stat_t.Rdev.
+    Rdev uint64
+
+    // Xattrs merges a format's extended attributes (tar's PAX
+    // SCHILY.xattr.* records and Header.Xattrs; zip has none yet) into one
+    // name -> value map.
+    Xattrs map[string][]byte
+
+    // PAXRecords carries a tar entry's raw PAX extended header records
+    // verbatim, including ones tarfs doesn't otherwise interpret (xattrs
+    // are already pulled out into Xattrs above); zip has none.
+    PAXRecords map[string]string
+
+    // ref is opaque backend-specific state (e.g. a tar data offset, or a
+    // zip.File index) that lets the same Backend find the entry's content
+    // again in OpenAt without re-enumerating the whole archive.
+    ref interface{}
+}
+
+// Backend adapts one concrete archive format to the generic Node tree built
+// by ReadArchive. A Backend instance is bound to a single opened archive.
+type Backend interface {
+    Enumerate() ([]Entry, error)
+    OpenAt(entry Entry, offset int64, length int64) (io.ReadCloser, error)
+    Close() error
+}
+
+type backendRegistration struct {
+    probe func(file *os.File) bool
+    open func(path string) (Backend, error)
+}
+
+var backends []backendRegistration
+
+// registerBackend adds a format to the set ReadArchive probes, in
+// registration order. probe must restore file's offset to 0 before
+// returning.
+func registerBackend(probe func(file *os.File) bool, open func(path string) (Backend, error)) {
+    backends = append(backends, backendRegistration{probe: probe, open: open})
+}
+
+// closeReadCloser pairs an io.Reader with one or more io.Closers, so backends
+// can hand out a section/flate reader while still closing the *os.File (and
+// any intermediate decompressor) it was built from.
+type closeReadCloser struct {
+    r io.Reader
+    closers []io.Closer
+}
+
+func (c *closeReadCloser) Read(buf []byte) (int, error) {
+    return c.r.Read(buf)
+}
+
+func (c *closeReadCloser) Close() error {
+    var err error
+    for _, closer := range c.closers {
+        if cerr := closer.Close(); cerr != nil && err == nil {
+            err = cerr
+        }
+    }
+    return err
+}
+
+// filePool is a small cache of open *os.File handles for one path, so
+// concurrent OpenAt calls can reuse a descriptor instead of opening (and
+// fd-churning) a fresh one per read. It has no upper bound on idle handles:
+// under steady concurrency it settles at roughly the peak number of readers
+// in flight.
+type filePool struct {
+    path string
+
+    mu sync.Mutex
+    idle []*os.File
+}
+
+func newFilePool(path string) *filePool {
+    return &filePool{path: path}
+}
+
+func (p *filePool) get() (*os.File, error) {
+    p.mu.Lock()
+    if n := len(p.idle); n > 0 {
+        file := p.idle[n-1]
+        p.idle = p.idle[:n-1]
+        p.mu.Unlock()
+        return file, nil
+    }
+    p.mu.Unlock()
+
+    return os.Open(p.path)
+}
+
+func (p *filePool) put(file *os.File) {
+    p.mu.Lock()
+    p.idle = append(p.idle, file)
+    p.mu.Unlock()
+}
+
+// closeAll closes every currently idle handle. Handles checked out via get
+// and not yet returned are the caller's responsibility.
+func (p *filePool) closeAll() error {
+    p.mu.Lock()
+    idle := p.idle
+    p.idle = nil
+    p.mu.Unlock()
+
+    var err error
+    for _, file := range idle {
+        if cerr := file.Close(); cerr != nil && err == nil {
+            err = cerr
+        }
+    }
+    return err
+}
+
+// pooledFile is an io.Closer that returns a pooled file handle instead of
+// closing it, for use as one of a closeReadCloser's closers.
+type pooledFile struct {
+    file *os.File
+    pool *filePool
+}
+
+func (p *pooledFile) Close() error {
+    p.pool.put(p.file)
+    return nil
+}
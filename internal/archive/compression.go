@@ -1,11 +1,13 @@
 package archive
 
 import (
+    "bytes"
     "compress/bzip2"
     "compress/gzip"
     "fmt"
     "io"
     "os"
+    "sync"
 
     "github.com/DataDog/zstd"
     "github.com/xi2/xz"
@@ -19,72 +21,130 @@ const (
     compressionXz string = "xz"
 )
 
-func isBzip2(file *os.File) bool {
-    file.Seek(0, 0)
-    reader := bzip2.NewReader(file)
-    buf := make([]byte, 16)
-    _, err := reader.Read(buf)
-    return err == nil
-}
+// Magic bytes for each supported compression, compared the same way as
+// Docker's archive.DetectCompression: a plain prefix match against the
+// file's first few bytes, no decoder round-trip required.
+var (
+    magicGzip = []byte{0x1F, 0x8B, 0x08}
+    magicBzip2 = []byte{0x42, 0x5A, 0x68}
+    magicXz = []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}
+    magicZstd = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
 
-func isGzip(file *os.File) bool {
-    file.Seek(0, 0)
-    _, err := gzip.NewReader(file)
-    return err == nil
-}
+func getCompression(file *os.File) string {
+    defer file.Seek(0, io.SeekStart)
+    file.Seek(0, io.SeekStart)
 
-func isZstd(file *os.File) bool {
-    file.Seek(0, 0)
-    reader := zstd.NewReader(file)
-    buf := make([]byte, 16)
-    _, err := reader.Read(buf)
-    return err == nil
+    header := make([]byte, 6)
+    n, _ := io.ReadFull(file, header)
+    return detectCompression(header[:n])
 }
 
-func isXz(file *os.File) bool {
-    file.Seek(0, 0)
-    _, err := xz.NewReader(file, 0)
-    return err == nil
-}
-
-func getCompression(file *os.File) string {
-    var compression string
-    if isBzip2(file) {
-        compression = compressionBzip2
-    } else if isGzip(file) {
-        compression = compressionGzip
-    } else if isZstd(file) {
-        compression = compressionZstd
-    } else if isXz(file) {
-        compression = compressionXz
-    } else {
-        compression = compressionNone
+// detectCompression is getCompression's magic-byte check, factored out so
+// it can also run against a header fetched by a single ranged read (see
+// tryRangedTar) instead of a local *os.File.
+func detectCompression(header []byte) string {
+    switch {
+    case bytes.HasPrefix(header, magicGzip):
+        return compressionGzip
+    case bytes.HasPrefix(header, magicBzip2):
+        return compressionBzip2
+    case bytes.HasPrefix(header, magicXz):
+        return compressionXz
+    case bytes.HasPrefix(header, magicZstd):
+        return compressionZstd
+    default:
+        return compressionNone
     }
-
-    return compression
 }
 
 func decompress(file *os.File, compression string) (io.Reader, error) {
     file.Seek(0, 0)
-    var reader io.Reader
     switch compression {
     case compressionBzip2:
-        reader = bzip2.NewReader(file)
+        return bzip2.NewReader(file), nil
     case compressionGzip:
-        reader, _ = gzip.NewReader(file)
+        // Unlike the other formats here, a gzip header's validity isn't
+        // fully captured by its magic prefix (e.g. a malformed FEXTRA
+        // field), so this is also what catches a corrupt/truncated
+        // .tar.gz instead of handing callers a nil reader.
+        return gzip.NewReader(file)
     case compressionZstd:
-        reader = zstd.NewReader(file)
+        return zstd.NewReader(file), nil
     case compressionXz:
-        var err error
-        reader, err = xz.NewReader(file, 0)
-        if err != nil {
-            panic(err)
-        }
+        return xz.NewReader(file, 0)
     case compressionNone:
-        reader = file
+        return file, nil
     default:
         return nil, fmt.Errorf(errorUnsupportedFormat)
     }
+}
 
-    return reader, nil
+// entryCacheBudget bounds how much decoded entry content a compressed
+// tarBackend keeps in entryCache at once. See entryCache's doc comment.
+const entryCacheBudget int64 = 64 << 20
+
+// entryCache is the LRU side of "seekable compression indices plus an LRU
+// of decoder states": none of bzip2/gzip/xz/zstd's Go decoders used here
+// expose the restart points that would let a fresh decode jump straight to
+// an arbitrary offset, so the best a repeat read of the same entry can do
+// is skip decoding it a second time. Content is keyed by an entry's
+// decompressed data offset (Entry.ref), which is unique per entry.
+//
+// This replaces unconditionally spooling the whole archive to a temp file
+// at open time: a compressed archive is now only ever decoded as far as
+// whatever entry content a caller actually asks for, not materialized in
+// full up front regardless of how much of it is ever read.
+type entryCache struct {
+    mu sync.Mutex
+    budget int64
+    used int64
+    order []int64
+    data map[int64][]byte
+}
+
+func newEntryCache(budget int64) *entryCache {
+    return &entryCache{budget: budget, data: make(map[int64][]byte)}
+}
+
+func (c *entryCache) get(offset int64) ([]byte, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    data, ok := c.data[offset]
+    if ok {
+        c.touch(offset)
+    }
+    return data, ok
+}
+
+func (c *entryCache) put(offset int64, data []byte) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if _, exists := c.data[offset]; !exists {
+        c.order = append(c.order, offset)
+    }
+    c.data[offset] = data
+    c.used += int64(len(data))
+
+    for c.used > c.budget && len(c.order) > 0 {
+        oldest := c.order[0]
+        c.order = c.order[1:]
+        if old, ok := c.data[oldest]; ok {
+            c.used -= int64(len(old))
+            delete(c.data, oldest)
+        }
+    }
+}
+
+// touch moves offset to the most-recently-used end of the eviction order.
+func (c *entryCache) touch(offset int64) {
+    for i, o := range c.order {
+        if o == offset {
+            c.order = append(c.order[:i], c.order[i+1:]...)
+            c.order = append(c.order, offset)
+            break
+        }
+    }
 }
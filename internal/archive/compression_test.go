@@ -0,0 +1,36 @@
+package archive
+
+import (
+    "os"
+    "testing"
+)
+
+// TestReadArchiveRejectsMalformedGzipHeader is the repro from the review
+// comment on decompress's gzip branch: a file with gzip's 3-byte magic but
+// a header FEXTRA flag set with no extra field data. Before the fix,
+// decompress silently discarded gzip.NewReader's header-parse error and
+// handed back a nil reader, which then panicked the first thing to read
+// from it instead of surfacing as an error.
+func TestReadArchiveRejectsMalformedGzipHeader(t *testing.T) {
+    file, err := os.CreateTemp("", "tarfs-malformed-*.tar.gz")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(file.Name())
+    defer file.Close()
+
+    // Magic + FLG with FEXTRA (0x04) set, MTIME/XFL/OS, then nothing: a
+    // valid gzip.NewReader call reads XLEN next because FEXTRA is set, but
+    // there's no more data, so it should fail instead of succeeding.
+    header := []byte{0x1F, 0x8B, 0x08, 0x04, 0, 0, 0, 0, 0, 0xFF}
+    if _, err := file.Write(header); err != nil {
+        t.Fatal(err)
+    }
+    if err := file.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := ReadArchive(file.Name()); err == nil {
+        t.Fatal("expected an error for a malformed gzip header, got nil")
+    }
+}
@@ -0,0 +1,219 @@
+package archive
+
+import (
+    "errors"
+    "io"
+    "io/fs"
+    "os"
+    "time"
+)
+
+var (
+    _ fs.FS = (*Archive)(nil)
+    _ fs.ReadDirFS = (*Archive)(nil)
+    _ fs.StatFS = (*Archive)(nil)
+    _ fs.ReadFileFS = (*Archive)(nil)
+)
+
+// buildFSIndex lazily indexes every Node by its FullName and synthesizes a
+// root Node (FullName "") for path resolution, the first time the io/fs.FS
+// API is used.
+func (arch *Archive) buildFSIndex() {
+    arch.fsOnce.Do(func() {
+        arch.root = &Node{Name: ".", Mode: os.ModeDir | 0555, Children: arch.Nodes, Archive: arch}
+
+        arch.pathIndex = make(map[string]*Node)
+        for i := range arch.Nodes {
+            indexNode(&arch.Nodes[i], arch.pathIndex)
+        }
+    })
+}
+
+func indexNode(node *Node, index map[string]*Node) {
+    index[node.FullName] = node
+    for i := range node.Children {
+        indexNode(&node.Children[i], index)
+    }
+}
+
+// resolve looks up name (an io/fs-style slash-separated path relative to the
+// archive root) and transparently follows TypeLink hardlinks to their
+// target. Symlinks are returned as-is rather than followed, same as a
+// format like embed.FS that has no notion of a symlink to chase.
+//
+// fs.ValidPath already rejects "..", absolute paths, and empty segments, so
+// there's no separate breakout check to write: a path that would escape the
+// archive root simply never appears in pathIndex.
+func (arch *Archive) resolve(op string, name string) (*Node, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+    }
+
+    arch.buildFSIndex()
+    if name == "." {
+        return arch.root, nil
+    }
+
+    node, ok := arch.pathIndex[name]
+    if !ok {
+        return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+    }
+
+    return arch.followLinks(op, node, 0)
+}
+
+func (arch *Archive) followLinks(op string, node *Node, depth int) (*Node, error) {
+    if !node.IsLink() {
+        return node, nil
+    }
+    if depth > 40 {
+        return nil, &fs.PathError{Op: op, Path: node.FullName, Err: errors.New("too many hardlinks")}
+    }
+
+    target, ok := arch.pathIndex[node.LinkName]
+    if !ok {
+        return nil, &fs.PathError{Op: op, Path: node.FullName, Err: fs.ErrNotExist}
+    }
+    return arch.followLinks(op, target, depth+1)
+}
+
+// Open implements fs.FS.
+func (arch *Archive) Open(name string) (fs.File, error) {
+    node, err := arch.resolve("open", name)
+    if err != nil {
+        return nil, err
+    }
+
+    if node.Mode.IsDir() {
+        return &dirFile{node: node}, nil
+    }
+
+    reader, err := node.Open()
+    if err != nil {
+        return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+    }
+    return &regularFile{node: node, ReadCloser: reader}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (arch *Archive) ReadDir(name string) ([]fs.DirEntry, error) {
+    node, err := arch.resolve("readdir", name)
+    if err != nil {
+        return nil, err
+    }
+    if !node.Mode.IsDir() {
+        return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+    }
+
+    entries := make([]fs.DirEntry, len(node.Children))
+    for i := range node.Children {
+        entries[i] = dirEntry{node: &node.Children[i]}
+    }
+    return entries, nil
+}
+
+// Stat implements fs.StatFS.
+func (arch *Archive) Stat(name string) (fs.FileInfo, error) {
+    node, err := arch.resolve("stat", name)
+    if err != nil {
+        return nil, err
+    }
+    return dirEntry{node: node}, nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (arch *Archive) ReadFile(name string) ([]byte, error) {
+    node, err := arch.resolve("read", name)
+    if err != nil {
+        return nil, err
+    }
+    if node.Mode.IsDir() {
+        return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+    }
+
+    reader, err := node.Open()
+    if err != nil {
+        return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+    }
+    defer reader.Close()
+
+    return io.ReadAll(reader)
+}
+
+// dirEntry adapts a Node to fs.DirEntry and fs.FileInfo. It's a separate
+// type rather than methods on Node itself because Node already has an
+// exported Name field, which would collide with the Name() method both
+// interfaces require.
+type dirEntry struct {
+    node *Node
+}
+
+var (
+    _ fs.DirEntry = dirEntry{}
+    _ fs.FileInfo = dirEntry{}
+)
+
+func (d dirEntry) Name() string { return d.node.Name }
+func (d dirEntry) IsDir() bool { return d.node.Mode.IsDir() }
+func (d dirEntry) Type() fs.FileMode { return d.node.Mode.Type() }
+func (d dirEntry) Info() (fs.FileInfo, error) { return d, nil }
+func (d dirEntry) Mode() fs.FileMode { return d.node.Mode }
+func (d dirEntry) ModTime() time.Time { return d.node.Mtime }
+func (d dirEntry) Sys() interface{} { return d.node }
+
+func (d dirEntry) Size() int64 {
+    if d.node.IsSymlink() {
+        return int64(len(d.node.LinkName))
+    }
+    return d.node.Size
+}
+
+// regularFile adapts a Node's content reader to fs.File.
+type regularFile struct {
+    node *Node
+    io.ReadCloser
+}
+
+func (f *regularFile) Stat() (fs.FileInfo, error) { return dirEntry{node: f.node}, nil }
+
+// dirFile adapts a directory Node to fs.ReadDirFile.
+type dirFile struct {
+    node *Node
+    offset int
+}
+
+var _ fs.ReadDirFile = (*dirFile)(nil)
+
+func (f *dirFile) Stat() (fs.FileInfo, error) { return dirEntry{node: f.node}, nil }
+func (f *dirFile) Close() error { return nil }
+
+func (f *dirFile) Read([]byte) (int, error) {
+    return 0, &fs.PathError{Op: "read", Path: f.node.FullName, Err: errors.New("is a directory")}
+}
+
+func (f *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+    remaining := f.node.Children[f.offset:]
+
+    if n <= 0 {
+        entries := make([]fs.DirEntry, len(remaining))
+        for i := range remaining {
+            entries[i] = dirEntry{node: &remaining[i]}
+        }
+        f.offset = len(f.node.Children)
+        return entries, nil
+    }
+
+    if len(remaining) == 0 {
+        return nil, io.EOF
+    }
+    if n > len(remaining) {
+        n = len(remaining)
+    }
+
+    entries := make([]fs.DirEntry, n)
+    for i := 0; i < n; i++ {
+        entries[i] = dirEntry{node: &remaining[i]}
+    }
+    f.offset += n
+    return entries, nil
+}
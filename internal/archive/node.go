@@ -1,7 +1,6 @@
 package archive
 
 import (
-    "archive/tar"
     "fmt"
     "io"
     "os"
@@ -9,21 +8,37 @@ import (
 )
 
 type Node struct {
-    index int
+    Index int
     Name string
     FullName string
     LinkName string
     Size int64
     Mode os.FileMode
-    typeflag byte
+    entryType EntryType
     Uid int
     Gid int
+    Uname string
+    Gname string
     Mtime time.Time
     Atime time.Time
     Ctime time.Time
     Parent *Node
     Children []Node
     Archive *Archive
+
+    // Rdev is the device number for TypeChar/TypeBlock entries.
+    Rdev uint64
+
+    // Xattrs holds the entry's extended attributes, keyed by attribute name.
+    Xattrs map[string][]byte
+
+    // PAXRecords holds the entry's raw PAX extended header records, keyed
+    // by record name (tar only; always nil for zip).
+    PAXRecords map[string]string
+
+    // entry is the Backend's own record for this member, handed back to
+    // Backend.OpenAt so it can locate the content again.
+    entry Entry
 }
 
 func (node *Node) listRecursive(nodes *[]*Node) {
@@ -34,47 +49,30 @@ func (node *Node) listRecursive(nodes *[]*Node) {
 }
 
 func (node *Node) IsLink() bool {
-    return node.typeflag == tar.TypeLink
+    return node.entryType == TypeLink
 }
 
 func (node *Node) IsSymlink() bool {
-    return node.typeflag == tar.TypeSymlink
+    return node.entryType == TypeSymlink
 }
 
+// Open returns a reader over the entry's full content.
 func (node *Node) Open() (io.ReadCloser, error) {
-    if !node.Mode.IsRegular() {
-        return nil, fmt.Errorf("Not a file")
-    }
+    return node.OpenAt(0, node.Size)
+}
 
-    file, err := os.Open(node.Archive.Path)
-    if err != nil {
-        return nil, err
+// OpenAt serves a random-access pread(offset, length) against the entry's
+// content. It's safe to call concurrently from multiple goroutines.
+func (node *Node) OpenAt(offset int64, length int64) (io.ReadCloser, error) {
+    if !node.Mode.IsRegular() {
+        return nil, fmt.Errorf("not a file")
     }
-
-    reader, err := node.Archive.Read(file)
-    if err != nil {
-        return nil, err
+    if offset < 0 || offset > node.Size {
+        return nil, fmt.Errorf("offset %d out of range for %q of size %d", offset, node.FullName, node.Size)
     }
-
-    for i := 0; i <= node.index; i++ {
-        if _, err := reader.Next(); err != nil {
-            return nil, err
-        }
+    if offset+length > node.Size {
+        length = node.Size - offset
     }
 
-    return &NodeReader{file: file, reader: reader}, nil
-}
-
-// implements io.ReadCloser
-type NodeReader struct {
-    file *os.File
-    reader *tar.Reader
-}
-
-func (nodeReader *NodeReader) Read(buf []byte) (int, error) {
-    return nodeReader.reader.Read(buf)
-}
-
-func (nodeReader *NodeReader) Close() error {
-    return nodeReader.file.Close()
+    return node.Archive.backend.OpenAt(node.entry, offset, length)
 }
@@ -0,0 +1,370 @@
+package archive
+
+import (
+    "archive/tar"
+    "bytes"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+func init() {
+    registerBackend(probeTar, openTar)
+}
+
+// tarBackend serves entries out of a (possibly compressed) tar stream.
+//
+// For a plain (uncompressed) tar, OpenAt reads are served with a direct
+// section read through pool: Enumerate records each entry's data offset in
+// Entry.ref, so serving M reads out of an N-entry tar costs O(M), not
+// O(N*M) re-scans of the stream.
+//
+// For a compressed tar, there's no such direct seek: decoding has to start
+// from the beginning of the compressed stream every time, since none of
+// bzip2/gzip/xz/zstd's Go decoders used here expose restart points partway
+// through. So rather than unconditionally paying that cost for the whole
+// archive up front (which is what this used to do, decompressing
+// everything to a temp file at open time regardless of what's ever read),
+// compressed entries are decoded on demand in OpenAt, and cache remembers
+// already-decoded entries so a repeat read of the same file doesn't decode
+// it again. See entryCache's doc comment in compression.go.
+//
+// OpenAt and Enumerate both read through pool rather than opening a fresh
+// *os.File per call, so concurrent readers reuse descriptors instead of
+// churning through open(2).
+//
+// ra/raSize are set instead of path/pool/cache when the backend was built
+// by openTarRanged: entries are then served straight off a ReaderAtSource
+// (e.g. an uncompressed tar over HTTP with Accept-Ranges: bytes) without
+// ever spooling the archive locally. See tryRangedTar in archive.go for how
+// that path gets selected.
+type tarBackend struct {
+    path string
+    compression string
+    pool *filePool
+    cache *entryCache
+
+    ra io.ReaderAt
+    raSize int64
+}
+
+func probeTar(file *os.File) bool {
+    defer file.Seek(0, io.SeekStart)
+
+    source, err := decompress(file, getCompression(file))
+    if err != nil {
+        return false
+    }
+
+    _, err = tar.NewReader(source).Next()
+    return err == nil
+}
+
+func openTar(path string) (Backend, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    compression := getCompression(file)
+    if compression != compressionNone {
+        // Sanity-check that the stream actually decodes before committing
+        // to this backend, so a corrupt/truncated compressed archive (a
+        // malformed gzip header, say) surfaces as an open-time error
+        // instead of a failure the first time something reads an entry.
+        source, err := decompress(file, compression)
+        if err != nil {
+            return nil, err
+        }
+        if _, err := io.CopyN(io.Discard, source, 1); err != nil && err != io.EOF {
+            return nil, err
+        }
+    }
+
+    return &tarBackend{
+        path: path,
+        compression: compression,
+        pool: newFilePool(path),
+        cache: newEntryCache(entryCacheBudget),
+    }, nil
+}
+
+// openTarRanged builds a tarBackend that enumerates and serves entries
+// straight off ra instead of a local/spooled file: Next() seeks over each
+// entry's data instead of reading through it (see readerAtSeeker), so
+// Enumerate only ever fetches header bytes, and OpenAt ranged-reads just
+// the bytes a caller asked for. Only valid for an uncompressed tar stream;
+// tryRangedTar checks that before calling this.
+func openTarRanged(ra io.ReaderAt, size int64) Backend {
+    return &tarBackend{compression: compressionNone, ra: ra, raSize: size}
+}
+
+func (b *tarBackend) Enumerate() ([]Entry, error) {
+    if b.ra != nil {
+        return b.enumerateRanged()
+    }
+
+    file, err := b.pool.get()
+    if err != nil {
+        return nil, err
+    }
+    defer b.pool.put(file)
+
+    source, err := decompress(file, b.compression)
+    if err != nil {
+        return nil, err
+    }
+
+    // counting tracks the reader's position in the decompressed stream, so
+    // an entry's data offset is known uniformly whether or not file itself
+    // (which only a plain tar's Seek would otherwise reflect) is the one
+    // tar.Reader is pulling bytes from.
+    counting := &countingReader{r: source}
+    reader := tar.NewReader(counting)
+
+    var entries []Entry
+    for {
+        header, err := reader.Next()
+        if err == io.EOF {
+            break
+        } else if err == tar.ErrHeader {
+            return nil, fmt.Errorf(errorUnsupportedFormat)
+        } else if err != nil {
+            return nil, err
+        }
+
+        entries = append(entries, entryFromTarHeader(header, counting.n))
+    }
+
+    return entries, nil
+}
+
+// countingReader tracks how many bytes have been read through it, standing
+// in for file.Seek(0, io.SeekCurrent) when the reader tar.Reader is pulling
+// from is a decompressor rather than the backing *os.File itself.
+type countingReader struct {
+    r io.Reader
+    n int64
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+    n, err := c.r.Read(buf)
+    c.n += int64(n)
+    return n, err
+}
+
+func (b *tarBackend) enumerateRanged() ([]Entry, error) {
+    source := &readerAtSeeker{ra: b.ra, size: b.raSize}
+    reader := tar.NewReader(source)
+
+    var entries []Entry
+    for {
+        header, err := reader.Next()
+        if err == io.EOF {
+            break
+        } else if err == tar.ErrHeader {
+            return nil, fmt.Errorf(errorUnsupportedFormat)
+        } else if err != nil {
+            return nil, err
+        }
+
+        entries = append(entries, entryFromTarHeader(header, source.pos))
+    }
+
+    return entries, nil
+}
+
+func entryFromTarHeader(header *tar.Header, dataOffset int64) Entry {
+    return Entry{
+        Name: header.Name,
+        LinkName: header.Linkname,
+        Size: header.Size,
+        Mode: header.FileInfo().Mode(),
+        Type: tarEntryType(header.Typeflag),
+        Uid: header.Uid,
+        Gid: header.Gid,
+        Uname: header.Uname,
+        Gname: header.Gname,
+        Mtime: header.ModTime,
+        Atime: header.AccessTime,
+        Ctime: header.ChangeTime,
+        Rdev: mkdev(header.Devmajor, header.Devminor),
+        Xattrs: tarXattrs(header),
+        PAXRecords: header.PAXRecords,
+        ref: dataOffset,
+    }
+}
+
+func (b *tarBackend) OpenAt(entry Entry, offset int64, length int64) (io.ReadCloser, error) {
+    dataOffset := entry.ref.(int64)
+
+    if b.ra != nil {
+        section := io.NewSectionReader(b.ra, dataOffset+offset, length)
+        return io.NopCloser(section), nil
+    }
+
+    if b.compression != compressionNone {
+        data, err := b.decodedEntry(dataOffset, entry.Size)
+        if err != nil {
+            return nil, err
+        }
+
+        end := offset + length
+        if end > int64(len(data)) {
+            end = int64(len(data))
+        }
+        if offset > end {
+            offset = end
+        }
+        return io.NopCloser(bytes.NewReader(data[offset:end])), nil
+    }
+
+    file, err := b.pool.get()
+    if err != nil {
+        return nil, err
+    }
+
+    section := io.NewSectionReader(file, dataOffset+offset, length)
+    return &closeReadCloser{r: section, closers: []io.Closer{&pooledFile{file: file, pool: b.pool}}}, nil
+}
+
+// decodedEntry returns a compressed entry's full decompressed content,
+// decoding the stream from the start and discarding bytes before dataOffset
+// on a cache miss. See entryCache's doc comment in compression.go for why
+// a repeat read only ever saves a second decode, not a head start on one.
+func (b *tarBackend) decodedEntry(dataOffset int64, size int64) ([]byte, error) {
+    if data, ok := b.cache.get(dataOffset); ok {
+        return data, nil
+    }
+
+    file, err := b.pool.get()
+    if err != nil {
+        return nil, err
+    }
+    defer b.pool.put(file)
+
+    source, err := decompress(file, b.compression)
+    if err != nil {
+        return nil, err
+    }
+
+    if _, err := io.CopyN(io.Discard, source, dataOffset); err != nil {
+        return nil, err
+    }
+
+    data := make([]byte, size)
+    if _, err := io.ReadFull(source, data); err != nil {
+        return nil, err
+    }
+
+    b.cache.put(dataOffset, data)
+    return data, nil
+}
+
+func (b *tarBackend) Close() error {
+    if b.ra != nil {
+        return nil
+    }
+
+    return b.pool.closeAll()
+}
+
+// readerAtSeeker adapts an io.ReaderAt into the io.ReadSeeker that
+// archive/tar.Reader looks for: archive/tar uses Seek (when available) to
+// skip over an entry's data instead of reading and discarding it, so
+// scanning a remote tar's headers through this type only ever ranged-reads
+// the headers themselves, never the bulk of the archive's content. Seeking
+// is free (just moves pos); nothing is fetched until the next Read.
+type readerAtSeeker struct {
+    ra io.ReaderAt
+    size int64
+    pos int64
+}
+
+func (r *readerAtSeeker) Read(buf []byte) (int, error) {
+    if r.pos >= r.size {
+        return 0, io.EOF
+    }
+    n, err := r.ra.ReadAt(buf, r.pos)
+    r.pos += int64(n)
+    if err == io.EOF && n > 0 {
+        err = nil
+    }
+    return n, err
+}
+
+func (r *readerAtSeeker) Seek(offset int64, whence int) (int64, error) {
+    var newPos int64
+    switch whence {
+    case io.SeekStart:
+        newPos = offset
+    case io.SeekCurrent:
+        newPos = r.pos + offset
+    case io.SeekEnd:
+        newPos = r.size + offset
+    default:
+        return 0, fmt.Errorf("readerAtSeeker: invalid whence %d", whence)
+    }
+    if newPos < 0 {
+        return 0, fmt.Errorf("readerAtSeeker: negative seek position %d", newPos)
+    }
+    r.pos = newPos
+    return r.pos, nil
+}
+
+func tarEntryType(flag byte) EntryType {
+    switch flag {
+    case tar.TypeDir:
+        return TypeDir
+    case tar.TypeSymlink:
+        return TypeSymlink
+    case tar.TypeLink:
+        return TypeLink
+    case tar.TypeChar:
+        return TypeChar
+    case tar.TypeBlock:
+        return TypeBlock
+    case tar.TypeFifo:
+        return TypeFifo
+    default:
+        // Includes TypeReg/TypeRegA and TypeGNUSparse: archive/tar already
+        // presents a sparse entry's expanded, logical byte stream, so it
+        // needs no special handling beyond looking like a regular file.
+        return TypeReg
+    }
+}
+
+// mkdev packs a device's major/minor numbers the same way Linux's
+// makedev(3) does, matching syscall.Stat_t.Rdev.
+func mkdev(major int64, minor int64) uint64 {
+    return uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12
+}
+
+// tarXattrs merges the two ways a tar header can carry extended attributes:
+// the legacy star/libarchive Header.Xattrs map, and PAX records using
+// restic/GNU tar's "SCHILY.xattr.<name>" convention.
+func tarXattrs(header *tar.Header) map[string][]byte {
+    var xattrs map[string][]byte
+
+    for name, value := range header.Xattrs {
+        if xattrs == nil {
+            xattrs = make(map[string][]byte)
+        }
+        xattrs[name] = []byte(value)
+    }
+
+    for key, value := range header.PAXRecords {
+        name, ok := strings.CutPrefix(key, "SCHILY.xattr.")
+        if !ok {
+            continue
+        }
+        if xattrs == nil {
+            xattrs = make(map[string][]byte)
+        }
+        xattrs[name] = []byte(value)
+    }
+
+    return xattrs
+}
@@ -0,0 +1,164 @@
+package archive
+
+import (
+    "os"
+    "path"
+    "strings"
+)
+
+// treeNode is buildTree's scratch representation: a Node plus the indices
+// of its children, kept separate from the final Node so we never hand out a
+// pointer into a []Node slice before it's done growing (Node.Children is
+// grown bottom-up in assembleNode instead).
+type treeNode struct {
+    node Node
+    childIndexes []int
+    synthesized bool
+}
+
+type treeBuilder struct {
+    arch *Archive
+    built []treeNode
+    indexByPath map[string]int
+    rootIndexes []int
+}
+
+// buildTree links a flat, backend-agnostic entry list into the hierarchical
+// Node tree the rest of tarfs expects. A directory that was never itself
+// emitted as an entry (very common for the parents of deeply nested files)
+// is synthesized on demand instead of being dropped.
+func buildTree(entries []Entry, arch *Archive) []Node {
+    b := &treeBuilder{arch: arch, indexByPath: make(map[string]int, len(entries))}
+
+    for _, entry := range entries {
+        name := normalizeEntryName(entry.Name)
+        if name == "" {
+            continue
+        }
+
+        node := nodeFromEntry(name, entry, arch)
+        if index, ok := b.indexByPath[name]; ok {
+            // A directory we had to synthesize earlier turned out to have
+            // its own header after all (or further down in the archive, in
+            // the case of a misordered tar); keep its place in the tree but
+            // adopt the real metadata.
+            node.Index = index
+            b.built[index].node = node
+            b.built[index].synthesized = false
+            continue
+        }
+
+        parentIndex := b.ensureDir(path.Dir(name))
+        b.addNode(parentIndex, node)
+    }
+
+    b.fillSyntheticTimes()
+
+    nodes := make([]Node, 0, len(b.rootIndexes))
+    for _, index := range b.rootIndexes {
+        nodes = append(nodes, assembleNode(index, b.built, nil))
+    }
+    return nodes
+}
+
+// ensureDir returns the built-index of dirPath, synthesizing it (and any of
+// its own missing ancestors) as an implicit directory if needed.
+func (b *treeBuilder) ensureDir(dirPath string) int {
+    if dirPath == "." || dirPath == "" {
+        return -1
+    }
+    if index, ok := b.indexByPath[dirPath]; ok {
+        return index
+    }
+
+    parentIndex := b.ensureDir(path.Dir(dirPath))
+    index := b.addNode(parentIndex, Node{
+        Name: path.Base(dirPath),
+        FullName: dirPath,
+        Mode: os.ModeDir | 0555,
+        entryType: TypeDir,
+        Archive: b.arch,
+    })
+    b.built[index].synthesized = true
+    return index
+}
+
+func (b *treeBuilder) addNode(parentIndex int, node Node) int {
+    index := len(b.built)
+    node.Index = index
+    b.built = append(b.built, treeNode{node: node})
+    b.indexByPath[node.FullName] = index
+
+    if parentIndex == -1 {
+        b.rootIndexes = append(b.rootIndexes, index)
+    } else {
+        b.built[parentIndex].childIndexes = append(b.built[parentIndex].childIndexes, index)
+    }
+    return index
+}
+
+// fillSyntheticTimes backfills the Mtime of every synthesized directory
+// with the earliest Mtime among its direct children, once all of them are
+// known. b.built is in ancestor-before-descendant order (ensureDir creates a
+// directory's missing ancestors before appending the directory itself), so
+// this walks it in reverse:
+// a multi-level synthesized chain (e.g. neither foo/ nor foo/bar/ present
+// for foo/bar/baz.txt) needs foo/bar/ to have already absorbed baz.txt's
+// Mtime before foo/ can absorb foo/bar/'s.
+func (b *treeBuilder) fillSyntheticTimes() {
+    for index := len(b.built) - 1; index >= 0; index-- {
+        if !b.built[index].synthesized {
+            continue
+        }
+
+        var earliest bool
+        for _, childIndex := range b.built[index].childIndexes {
+            childTime := b.built[childIndex].node.Mtime
+            if childTime.IsZero() {
+                continue
+            }
+            if !earliest || childTime.Before(b.built[index].node.Mtime) {
+                b.built[index].node.Mtime = childTime
+                earliest = true
+            }
+        }
+    }
+}
+
+func nodeFromEntry(name string, entry Entry, arch *Archive) Node {
+    return Node{
+        Name: path.Base(name),
+        FullName: name,
+        LinkName: entry.LinkName,
+        Size: entry.Size,
+        Uid: entry.Uid,
+        Gid: entry.Gid,
+        Uname: entry.Uname,
+        Gname: entry.Gname,
+        Mode: entry.Mode,
+        entryType: entry.Type,
+        Mtime: entry.Mtime,
+        Atime: entry.Atime,
+        Ctime: entry.Ctime,
+        Rdev: entry.Rdev,
+        Xattrs: entry.Xattrs,
+        PAXRecords: entry.PAXRecords,
+        Archive: arch,
+        entry: entry,
+    }
+}
+
+func assembleNode(index int, built []treeNode, parent *Node) Node {
+    node := built[index].node
+    node.Parent = parent
+    for _, childIndex := range built[index].childIndexes {
+        node.Children = append(node.Children, assembleNode(childIndex, built, &node))
+    }
+    return node
+}
+
+func normalizeEntryName(name string) string {
+    name = strings.TrimPrefix(name, "/")
+    name = strings.TrimPrefix(name, "./")
+    return strings.TrimSuffix(name, "/")
+}
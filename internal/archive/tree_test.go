@@ -0,0 +1,40 @@
+package archive
+
+import (
+    "os"
+    "testing"
+    "time"
+)
+
+// TestBuildTreeSynthesizesAncestorMtimesBottomUp is the repro from the
+// review comment on fillSyntheticTimes: foo/bar/baz.txt with neither foo/
+// nor foo/bar/ present as their own entries. Both synthesized ancestors
+// should end up with baz.txt's Mtime, not just the immediate parent.
+func TestBuildTreeSynthesizesAncestorMtimesBottomUp(t *testing.T) {
+    mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    nodes := buildTree([]Entry{
+        {
+            Name: "foo/bar/baz.txt",
+            Mode: os.FileMode(0644),
+            Type: TypeReg,
+            Mtime: mtime,
+        },
+    }, &Archive{})
+
+    if len(nodes) != 1 || nodes[0].Name != "foo" {
+        t.Fatalf("expected a single root node \"foo\", got %+v", nodes)
+    }
+    foo := nodes[0]
+    if !foo.Mtime.Equal(mtime) {
+        t.Errorf("foo: want Mtime %v, got %v", mtime, foo.Mtime)
+    }
+
+    if len(foo.Children) != 1 || foo.Children[0].Name != "bar" {
+        t.Fatalf("expected foo to have a single child \"bar\", got %+v", foo.Children)
+    }
+    bar := foo.Children[0]
+    if !bar.Mtime.Equal(mtime) {
+        t.Errorf("foo/bar: want Mtime %v, got %v", mtime, bar.Mtime)
+    }
+}
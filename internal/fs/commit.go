@@ -0,0 +1,147 @@
+package fs
+
+import (
+    "archive/tar"
+    "io"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/st3iny/tarfs/internal/archive"
+    "github.com/st3iny/tarfs/internal/overlay"
+)
+
+// Commit writes the merged view of arch and ov out to outPath as a new,
+// plain (uncompressed) tar: every lower entry that hasn't been deleted or
+// superseded, plus every dirty overlay file's current content. It doesn't
+// touch arch or ov.
+func Commit(arch *archive.Archive, ov *overlay.Overlay, outPath string) error {
+    out, err := os.Create(outPath)
+    if err != nil {
+        return err
+    }
+    defer out.Close()
+
+    writer := tar.NewWriter(out)
+    defer writer.Close()
+
+    renames := ov.Renames()
+
+    written := make(map[string]bool)
+    for _, node := range arch.List() {
+        if node.FullName == "" {
+            continue
+        }
+
+        // A renamed entry's old path is always whited out, so check the path
+        // it now lives at instead: that catches a delete after the rename.
+        name, _ := relocate(node.FullName, renames)
+        if ov.IsDeleted(name) {
+            continue
+        }
+        written[name] = true
+
+        if data, ok := ov.ReadAll(name); ok {
+            if err := writeTarFile(writer, name, node.Mode, node.Uid, node.Gid, node.Mtime, data); err != nil {
+                return err
+            }
+            continue
+        }
+
+        if err := writeTarNode(writer, node, name); err != nil {
+            return err
+        }
+    }
+
+    for _, path := range ov.DirtyPaths() {
+        if written[path] || ov.IsDeleted(path) {
+            continue
+        }
+        data, _ := ov.ReadAll(path)
+        if err := writeTarFile(writer, path, 0644, 0, 0, time.Time{}, data); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// relocate reports the path a node was moved to by a still-pristine rename,
+// either of the node itself or of an ancestor directory it's nested under.
+func relocate(fullName string, renames map[string]string) (string, bool) {
+    if newName, ok := renames[fullName]; ok {
+        return newName, true
+    }
+    for oldPrefix, newPrefix := range renames {
+        if rest, ok := strings.CutPrefix(fullName, oldPrefix+"/"); ok {
+            return newPrefix + "/" + rest, true
+        }
+    }
+    return fullName, false
+}
+
+func writeTarNode(writer *tar.Writer, node *archive.Node, name string) error {
+    header := &tar.Header{
+        Name: name,
+        Mode: int64(node.Mode.Perm()),
+        Uid: node.Uid,
+        Gid: node.Gid,
+        ModTime: node.Mtime,
+        Size: node.Size,
+    }
+
+    switch {
+    case node.Mode.IsDir():
+        header.Typeflag = tar.TypeDir
+        header.Size = 0
+    case node.IsSymlink():
+        header.Typeflag = tar.TypeSymlink
+        header.Linkname = node.LinkName
+        header.Size = 0
+    case node.IsLink():
+        header.Typeflag = tar.TypeLink
+        header.Linkname = node.LinkName
+        header.Size = 0
+    default:
+        header.Typeflag = tar.TypeReg
+    }
+
+    if err := writer.WriteHeader(header); err != nil {
+        return err
+    }
+    if header.Typeflag != tar.TypeReg {
+        return nil
+    }
+
+    reader, err := node.Open()
+    if err != nil {
+        return err
+    }
+    defer reader.Close()
+
+    _, err = io.Copy(writer, reader)
+    return err
+}
+
+func writeTarFile(writer *tar.Writer, name string, mode os.FileMode, uid int, gid int, mtime time.Time, data []byte) error {
+    perm := mode.Perm()
+    if perm == 0 {
+        perm = 0644
+    }
+
+    header := &tar.Header{
+        Name: name,
+        Typeflag: tar.TypeReg,
+        Mode: int64(perm),
+        Uid: uid,
+        Gid: gid,
+        ModTime: mtime,
+        Size: int64(len(data)),
+    }
+    if err := writer.WriteHeader(header); err != nil {
+        return err
+    }
+
+    _, err := writer.Write(data)
+    return err
+}
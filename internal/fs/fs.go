@@ -1,262 +1,577 @@
 package fs
 
 import (
+    "context"
     "io"
-    "log"
     "os"
+    "os/user"
     "strconv"
     "syscall"
-    "os/user"
+    "time"
 
     "github.com/st3iny/tarfs/internal/archive"
+    "github.com/st3iny/tarfs/internal/overlay"
 
-    "bazil.org/fuse"
-    "bazil.org/fuse/fs"
-    "golang.org/x/net/context"
+    gofs "github.com/hanwen/go-fuse/v2/fs"
+    "github.com/hanwen/go-fuse/v2/fuse"
 )
 
-func MountAndServe(archivePath string, mountpoint string) error {
-    c, err := fuse.Mount(
-        mountpoint,
-        fuse.FSName("tarfs"),
-        fuse.Subtype("tarfs"),
-        fuse.ReadOnly(),
-    )
-    if err != nil {
-        return err
-    }
-    defer c.Close()
+// Options configures the FUSE mount. The zero value mounts read-only with
+// the kernel's default cache timeouts and the current user as owner of the
+// mount's root directory. Uid and Gid override that root owner only; entries
+// from the archive keep their own recorded Uid/Gid.
+type Options struct {
+    Debug bool
+    AllowOther bool
+    EntryTimeout *time.Duration
+    AttrTimeout *time.Duration
+    Uid *uint32
+    Gid *uint32
+}
+
+// MountAndServe mounts the archive at archivePath at mountpoint read-only and
+// blocks until it's unmounted.
+func MountAndServe(archivePath string, mountpoint string, opts Options) error {
+    return mountAndServe(archivePath, mountpoint, opts, nil)
+}
 
-    srv := fs.New(c, nil)
+// MountAndServeRW is MountAndServe plus a writable, in-memory copy-on-write
+// overlay: creates, writes, deletes and renames are kept in the overlay and
+// never touch the archive, so the same read-only archive file can be mounted
+// writably. The overlay lives only as long as the mount: MountAndServeRW
+// does not hand its Archive or Overlay to the caller, so its changes cannot
+// be passed to Commit and are discarded on unmount.
+func MountAndServeRW(archivePath string, mountpoint string, opts Options) error {
+    return mountAndServe(archivePath, mountpoint, opts, overlay.New())
+}
 
-    filesys, err := createFS(archivePath)
+func mountAndServe(archivePath string, mountpoint string, opts Options, ov *overlay.Overlay) error {
+    arch, err := archive.ReadArchive(archivePath)
     if err != nil {
         return err
     }
 
-    if err := srv.Serve(filesys); err != nil {
-        return err
+    root := newRoot(arch, opts, ov)
+
+    mountOpts := &gofs.Options{
+        EntryTimeout: opts.EntryTimeout,
+        AttrTimeout: opts.AttrTimeout,
+        MountOptions: fuse.MountOptions{
+            FsName: "tarfs",
+            Name: "tarfs",
+            Debug: opts.Debug,
+            AllowOther: opts.AllowOther,
+        },
     }
 
-    // check if the mount process has an error to report.
-    <-c.Ready
-    if err := c.MountError; err != nil {
+    server, err := gofs.Mount(mountpoint, root, mountOpts)
+    if err != nil {
         return err
     }
+
+    server.Wait()
     return nil
 }
 
-func createFS(archivePath string) (*FS, error) {
-    var arch *archive.Archive
-    arch, err := archive.ReadArchive(archivePath)
-    if err != nil {
-        return nil, err
-    }
+// File is the fs.InodeEmbedder backing every entry in the tree, including
+// the synthetic root. The real tree is materialized once at mount time by
+// Root.OnAdd; File itself just serves attrs/reads/readlinks for one node.
+//
+// In a read-only mount overlay is nil and node is always set. In a writable
+// mount (MountAndServeRW) overlay is shared by every File and node is nil
+// for anything created after mount (via Create/Mkdir/Symlink), which instead
+// carries its own synth* fields.
+type File struct {
+    gofs.Inode
+
+    node *archive.Node
+    overlay *overlay.Overlay
+
+    renamedPath string
+    synthPath string
+    synthMode os.FileMode
+    synthUid uint32
+    synthGid uint32
+    synthMtime time.Time
+    synthTarget string
+}
 
-    uid := int64(0)
-    gid := int64(0)
+// Root is the filesystem root. Its archive.Node synthesizes a directory
+// whose Children are the archive's top-level entries.
+type Root struct {
+    File
+}
 
-    user, err := user.Current()
-    if err == nil {
-        uid, _ = strconv.ParseInt(user.Uid, 10, 32)
-        gid, _ = strconv.ParseInt(user.Gid, 10, 32)
+func newRoot(arch *archive.Archive, opts Options, ov *overlay.Overlay) *Root {
+    uid, gid := currentOwner()
+    if opts.Uid != nil {
+        uid = *opts.Uid
+    }
+    if opts.Gid != nil {
+        gid = *opts.Gid
     }
 
-    root := &archive.Node{
+    rootNode := &archive.Node{
         Name: "root",
         FullName: "",
         Mode: os.ModeDir | 0555,
         Uid: int(uid),
         Gid: int(gid),
         Children: arch.Nodes,
-        Archive: arch,
     }
 
-    filesys := &FS{
-        Archive: *arch,
-        RootNode: File{Node: root},
+    return &Root{File: File{node: rootNode, overlay: ov}}
+}
+
+// path returns the key overlay records and whiteouts for this file are
+// tracked under: the archive path for lower-layer nodes (unless they've
+// since been renamed), or the synthetic path assigned at Create/Mkdir/Symlink
+// time.
+func (f *File) path() string {
+    if f.renamedPath != "" {
+        return f.renamedPath
+    }
+    if f.node != nil {
+        return f.node.FullName
+    }
+    return f.synthPath
+}
+
+func (f *File) mode() os.FileMode {
+    if f.node != nil {
+        return f.node.Mode
     }
+    return f.synthMode
+}
 
-    linkMap := createLinkMap(arch, filesys)
-    filesys.LinkMap = linkMap
-    filesys.RootNode.FS = filesys
+func (f *File) uid() uint32 {
+    if f.node != nil {
+        return uint32(f.node.Uid)
+    }
+    return f.synthUid
+}
 
-    return filesys, nil
+func (f *File) gid() uint32 {
+    if f.node != nil {
+        return uint32(f.node.Gid)
+    }
+    return f.synthGid
 }
 
-func createLinkMap(archive *archive.Archive, filesys *FS) map[string]*File  {
-    linkMap := make(map[string]*File)
-    for _, node := range archive.List() {
-        if node.IsLink() {
-            linkMap[node.LinkName] = nil
+func (f *File) size() int64 {
+    if f.overlay != nil {
+        if size, ok := f.overlay.Size(f.path()); ok {
+            return size
+        }
+    }
+    if f.node != nil {
+        if f.node.IsSymlink() {
+            return int64(len(f.node.LinkName))
         }
+        return f.node.Size
+    }
+    if f.synthMode&os.ModeSymlink != 0 {
+        return int64(len(f.synthTarget))
+    }
+    return 0
+}
+
+// lowerBytes reads this file's full content from the archive, for the
+// overlay to fault in on first write. Synthetic files (created after mount)
+// have no lower content.
+func (f *File) lowerBytes() ([]byte, error) {
+    if f.node == nil || !f.node.Mode.IsRegular() {
+        return []byte{}, nil
+    }
+    reader, err := f.node.Open()
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+    return io.ReadAll(reader)
+}
+
+func joinPath(dir string, name string) string {
+    if dir == "" {
+        return name
+    }
+    return dir + "/" + name
+}
+
+func currentOwner() (uid uint32, gid uint32) {
+    u, err := user.Current()
+    if err != nil {
+        return 0, 0
+    }
+
+    if parsed, err := strconv.ParseUint(u.Uid, 10, 32); err == nil {
+        uid = uint32(parsed)
+    }
+    if parsed, err := strconv.ParseUint(u.Gid, 10, 32); err == nil {
+        gid = uint32(parsed)
     }
+    return uid, gid
+}
+
+var _ gofs.NodeOnAdder = (*Root)(nil)
+
+// OnAdd walks the archive's Node tree once at mount time and materializes
+// it as persistent inodes, so Lookup never has to touch the archive.
+// Hardlinks are resolved in a second pass and share their target's inode
+// rather than getting a Node of their own.
+func (r *Root) OnAdd(ctx context.Context) {
+    byPath := make(map[string]*gofs.Inode)
+    var links []*archive.Node
+
+    var walk func(parent *gofs.Inode, node *archive.Node)
+    walk = func(parent *gofs.Inode, node *archive.Node) {
+        for i := range node.Children {
+            child := &node.Children[i]
+            if child.IsLink() {
+                links = append(links, child)
+                continue
+            }
+
+            stable := gofs.StableAttr{Mode: modeToStableAttr(child.Mode), Ino: uint64(child.Index) + 1}
+            inode := parent.NewPersistentInode(ctx, &File{node: child, overlay: r.overlay}, stable)
+            parent.AddChild(child.Name, inode, true)
+            byPath[child.FullName] = inode
 
-    for _, node := range archive.List() {
-        if _, present := linkMap[node.FullName]; present {
-            file := &File{
-                Node: node,
-                FS: filesys,
+            if child.Mode.IsDir() {
+                walk(inode, child)
             }
-            linkMap[node.FullName] = file
         }
     }
+    walk(&r.Inode, r.node)
+
+    for _, link := range links {
+        target, ok := byPath[link.LinkName]
+        if !ok {
+            continue
+        }
 
-    log.Println("found", len(linkMap), "hardlinks in archive")
-    return linkMap
+        parent := &r.Inode
+        if link.Parent != nil && link.Parent.FullName != "" {
+            if p, ok := byPath[link.Parent.FullName]; ok {
+                parent = p
+            }
+        }
+        parent.AddChild(link.Name, target, true)
+    }
 }
 
-type FS struct {
-    Archive archive.Archive
-    RootNode File
-    LinkMap map[string]*File
+func modeToStableAttr(mode os.FileMode) uint32 {
+    switch {
+    case mode.IsDir():
+        return syscall.S_IFDIR
+    case mode&os.ModeSymlink != 0:
+        return syscall.S_IFLNK
+    case mode&os.ModeNamedPipe != 0:
+        return syscall.S_IFIFO
+    case mode&os.ModeSocket != 0:
+        return syscall.S_IFSOCK
+    case mode&os.ModeCharDevice != 0:
+        return syscall.S_IFCHR
+    case mode&os.ModeDevice != 0:
+        return syscall.S_IFBLK
+    default:
+        return syscall.S_IFREG
+    }
 }
 
-type File struct {
-    Node *archive.Node
-    FS *FS
+var _ gofs.NodeGetattrer = (*File)(nil)
+
+func (f *File) Getattr(ctx context.Context, fh gofs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+    f.fillAttr(&out.Attr)
+    return 0
 }
 
-type FileHandle struct {
-    File *File
-    Reader io.ReadCloser
-    Offset int64
+func (f *File) fillAttr(out *fuse.Attr) {
+    if f.node != nil {
+        out.Ino = uint64(f.node.Index) + 1
+        out.Rdev = uint32(f.node.Rdev)
+        out.SetTimes(&f.node.Atime, &f.node.Mtime, &f.node.Ctime)
+    } else {
+        out.SetTimes(&f.synthMtime, &f.synthMtime, &f.synthMtime)
+    }
+    out.Mode = uint32(f.mode().Perm())
+    out.Size = uint64(f.size())
+    out.Uid = f.uid()
+    out.Gid = f.gid()
 }
 
-var _ fs.FS = (*FS)(nil)
-// var _ fs.Node = (*File)(nil)
-// var _ fs.Handle = (*FileHandle)(nil)
+var _ gofs.NodeReadlinker = (*File)(nil)
 
-func (f *FS) Root() (fs.Node, error) {
-    return &f.RootNode, nil
+func (f *File) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+    if f.node != nil {
+        return []byte(f.node.LinkName), 0
+    }
+    return []byte(f.synthTarget), 0
 }
 
-var _ fs.NodeStringLookuper = (*File)(nil)
+var _ gofs.NodeOpener = (*File)(nil)
 
-func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
-    blocks := uint64(f.Node.Size) / 512
-    if blocks % 512 > 0 {
-        blocks++
+func (f *File) Open(ctx context.Context, flags uint32) (gofs.FileHandle, uint32, syscall.Errno) {
+    writeRequested := flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+    if writeRequested && f.overlay == nil {
+        return nil, 0, syscall.EACCES
     }
-
-    if f.Node.IsLink() {
-        return f.FS.LinkMap[f.Node.LinkName].Attr(ctx, a)
+    if writeRequested && flags&syscall.O_TRUNC != 0 {
+        if err := f.overlay.Truncate(f.path(), 0, f.lowerBytes); err != nil {
+            return nil, 0, syscall.EIO
+        }
     }
-
-    a.Inode = uint64(f.Node.Index)
-    if f.Node.IsSymlink() {
-        a.Size = uint64(len(f.Node.LinkName))
-    } else {
-        a.Size = uint64(f.Node.Size)
-    }
-    a.Blocks = blocks
-    a.Mode = f.Node.Mode
-    a.Uid = uint32(f.Node.Uid)
-    a.Gid = uint32(f.Node.Gid)
-    a.Mtime = f.Node.Mtime
-    a.Atime = f.Node.Atime
-    a.Ctime = f.Node.Ctime
-    return nil
+    return nil, fuse.FOPEN_KEEP_CACHE, 0
 }
 
-func (f *File) Lookup(ctx context.Context, name string) (fs.Node, error) {
-    for index, _ := range f.Node.Children {
-        child := &f.Node.Children[index]
-        if name == child.Name {
-            return &File{Node: child, FS: f.FS}, nil
+var _ gofs.NodeReader = (*File)(nil)
+
+// Read serves each request as an independent pread, which is what lets
+// go-fuse issue concurrent/out-of-order reads for a single open file. A
+// dirty overlay record (if any) takes priority over the archive.
+func (f *File) Read(ctx context.Context, fh gofs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+    if f.overlay != nil {
+        if n, ok := f.overlay.ReadAt(f.path(), dest, off); ok {
+            return fuse.ReadResultData(dest[:n]), 0
         }
     }
 
-    return nil, fuse.ENOENT
+    if f.node == nil {
+        return fuse.ReadResultData(nil), 0
+    }
+
+    reader, err := f.node.OpenAt(off, int64(len(dest)))
+    if err != nil {
+        return nil, syscall.EIO
+    }
+    defer reader.Close()
+
+    n, err := io.ReadFull(reader, dest)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+        return nil, syscall.EIO
+    }
+
+    return fuse.ReadResultData(dest[:n]), 0
 }
 
-var _ fs.HandleReadDirAller = (*File)(nil)
+var _ gofs.NodeWriter = (*File)(nil)
 
-func (f *File) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-    entries := make([]fuse.Dirent, 0, len(f.Node.Children))
+func (f *File) Write(ctx context.Context, fh gofs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+    if f.overlay == nil {
+        return 0, syscall.EROFS
+    }
 
-    for _, node := range f.Node.Children {
-        entryType := fuse.DT_File
-        if node.Mode.IsDir() {
-            entryType = fuse.DT_Dir
-        }
+    n, err := f.overlay.WriteAt(f.path(), data, off, f.lowerBytes)
+    if err != nil {
+        return 0, syscall.EIO
+    }
+    return uint32(n), 0
+}
+
+var _ gofs.NodeSetattrer = (*File)(nil)
 
-        entry := fuse.Dirent{
-            Inode: uint64(node.Index),
-            Name: node.Name,
-            Type: entryType,
+func (f *File) Setattr(ctx context.Context, fh gofs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+    if size, ok := in.GetSize(); ok {
+        if f.overlay == nil {
+            return syscall.EROFS
         }
+        if err := f.overlay.Truncate(f.path(), int64(size), f.lowerBytes); err != nil {
+            return syscall.EIO
+        }
+    }
+    return f.Getattr(ctx, fh, out)
+}
+
+var _ gofs.NodeCreater = (*File)(nil)
 
-        entries = append(entries, entry)
+func (f *File) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*gofs.Inode, gofs.FileHandle, uint32, syscall.Errno) {
+    if f.overlay == nil {
+        return nil, nil, 0, syscall.EROFS
     }
 
-    return entries, nil
+    childPath := joinPath(f.path(), name)
+    f.overlay.Create(childPath)
+
+    child := &File{
+        overlay: f.overlay,
+        synthPath: childPath,
+        synthMode: os.FileMode(mode & 0777),
+        synthUid: f.uid(),
+        synthGid: f.gid(),
+        synthMtime: time.Now(),
+    }
+    inode := f.NewInode(ctx, child, gofs.StableAttr{Mode: syscall.S_IFREG})
+    f.AddChild(name, inode, true)
+
+    child.fillAttr(&out.Attr)
+    return inode, nil, fuse.FOPEN_KEEP_CACHE, 0
 }
 
-var _ fs.NodeReadlinker = (*File)(nil)
+var _ gofs.NodeMkdirer = (*File)(nil)
+
+func (f *File) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+    if f.overlay == nil {
+        return nil, syscall.EROFS
+    }
+
+    child := &File{
+        overlay: f.overlay,
+        synthPath: joinPath(f.path(), name),
+        synthMode: os.ModeDir | os.FileMode(mode&0777),
+        synthUid: f.uid(),
+        synthGid: f.gid(),
+        synthMtime: time.Now(),
+    }
+    inode := f.NewInode(ctx, child, gofs.StableAttr{Mode: syscall.S_IFDIR})
+    f.AddChild(name, inode, true)
 
-func (f *File) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
-    return f.Node.LinkName, nil
+    child.fillAttr(&out.Attr)
+    return inode, 0
 }
 
-var _ fs.NodeOpener = (*File)(nil)
+var _ gofs.NodeSymlinker = (*File)(nil)
 
-func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
-    if !req.Flags.IsReadOnly() {
-        return nil, fuse.Errno(syscall.EACCES)
+func (f *File) Symlink(ctx context.Context, target string, name string, out *fuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+    if f.overlay == nil {
+        return nil, syscall.EROFS
     }
-    resp.Flags |= fuse.OpenKeepCache
-    resp.Flags |= fuse.OpenNonSeekable
 
-    reader, err := f.Node.Open()
-    if err != nil {
-        return nil, fuse.EIO
+    child := &File{
+        overlay: f.overlay,
+        synthPath: joinPath(f.path(), name),
+        synthMode: os.ModeSymlink | 0777,
+        synthUid: f.uid(),
+        synthGid: f.gid(),
+        synthMtime: time.Now(),
+        synthTarget: target,
     }
+    inode := f.NewInode(ctx, child, gofs.StableAttr{Mode: syscall.S_IFLNK})
+    f.AddChild(name, inode, true)
 
-    if f.Node.Mode.IsDir() {
-        return f, nil
-    } else if f.Node.IsLink() {
-        return f.FS.LinkMap[f.Node.LinkName].Open(ctx, req, resp)
-    } else {
-        fh := &FileHandle{
-            File: f,
-            Reader: reader,
-            Offset: 0,
-        }
-        return fh, nil
+    child.fillAttr(&out.Attr)
+    return inode, 0
+}
+
+var _ gofs.NodeUnlinker = (*File)(nil)
+
+func (f *File) Unlink(ctx context.Context, name string) syscall.Errno {
+    if f.overlay == nil {
+        return syscall.EROFS
     }
+    f.overlay.Delete(joinPath(f.path(), name))
+    f.Inode.RmChild(name)
+    return 0
+}
 
+var _ gofs.NodeRmdirer = (*File)(nil)
+
+func (f *File) Rmdir(ctx context.Context, name string) syscall.Errno {
+    return f.Unlink(ctx, name)
 }
 
-var _ fs.HandleReader = (*FileHandle)(nil)
+var _ gofs.NodeRenamer = (*File)(nil)
 
-func (fh *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-    if fh.Offset != req.Offset {
-        return fuse.ENOTSUP
+func (f *File) Rename(ctx context.Context, name string, newParent gofs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+    if f.overlay == nil {
+        return syscall.EROFS
     }
 
-    buf := make([]byte, req.Size)
-    count, err := fh.Reader.Read(buf)
-    if err != nil && err != io.EOF {
-        return fuse.EIO
+    newParentInode := newParent.EmbeddedInode()
+    newParentFile, _ := newParentInode.Operations().(*File)
+    var newPath string
+    if newParentFile != nil {
+        newPath = joinPath(newParentFile.path(), newName)
+    } else {
+        newPath = newName
     }
 
-    if count != req.Size {
-        buf = buf[:count]
+    child := f.Inode.GetChild(name)
+    oldPath := joinPath(f.path(), name)
+    if child != nil {
+        if childFile, ok := child.Operations().(*File); ok && childFile.node != nil {
+            // Use the node's original archive path rather than its current
+            // (possibly already-renamed) one, so a chain of renames keeps
+            // updating the same overlay/rename record instead of losing
+            // track of it.
+            oldPath = childFile.node.FullName
+        }
+    }
+    f.overlay.Rename(oldPath, newPath)
+
+    f.Inode.RmChild(name)
+    if child != nil {
+        // Renaming a directory moves every descendant along with it: each
+        // one needs its own renamedPath updated, not just child's, or a
+        // nested file's path() keeps returning its stale pre-rename
+        // FullName and a write after the rename keys the overlay record
+        // under a path Commit will never look for it under.
+        propagateRename(child, newPath)
+        newParentInode.AddChild(newName, child, true)
     }
-    fh.Offset += int64(count)
 
-    resp.Data = buf
-    return nil
+    return 0
+}
+
+// propagateRename sets renamedPath to path on the File backing inode, then
+// recurses over its children (if any) with each child's name appended, so a
+// whole renamed subtree's effective path() stays in sync with the live
+// inode tree instead of just the directly-renamed node's.
+func propagateRename(inode *gofs.Inode, path string) {
+    if file, ok := inode.Operations().(*File); ok {
+        file.renamedPath = path
+    }
+    for childName, child := range inode.Children() {
+        propagateRename(child, joinPath(path, childName))
+    }
 }
 
-var _ fs.HandleReleaser = (*FileHandle)(nil)
+var _ gofs.NodeFsyncer = (*File)(nil)
 
-func (fh *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-    err := fh.Reader.Close()
-    if err != nil {
-        return fuse.EIO
+// Fsync is a no-op: writes are kept entirely in memory until Commit.
+func (f *File) Fsync(ctx context.Context, fh gofs.FileHandle, flags uint32) syscall.Errno {
+    return 0
+}
+
+var _ gofs.NodeGetxattrer = (*File)(nil)
+
+func (f *File) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+    value, ok := f.xattrs()[attr]
+    if !ok {
+        return 0, syscall.ENODATA
+    }
+    if len(dest) < len(value) {
+        return uint32(len(value)), syscall.ERANGE
     }
+    return uint32(copy(dest, value)), 0
+}
 
-    return nil
+var _ gofs.NodeListxattrer = (*File)(nil)
+
+func (f *File) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+    xattrs := f.xattrs()
+
+    var size uint32
+    for name := range xattrs {
+        size += uint32(len(name)) + 1
+    }
+    if uint32(len(dest)) < size {
+        return size, syscall.ERANGE
+    }
+
+    var offset int
+    for name := range xattrs {
+        offset += copy(dest[offset:], name)
+        dest[offset] = 0
+        offset++
+    }
+    return uint32(offset), 0
+}
+
+// xattrs returns the node's extended attributes, or nil for a synthetic
+// file created after mount (go-fuse treats a nil map as "no xattrs").
+func (f *File) xattrs() map[string][]byte {
+    if f.node == nil {
+        return nil
+    }
+    return f.node.Xattrs
 }
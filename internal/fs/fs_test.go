@@ -0,0 +1,133 @@
+package fs
+
+import (
+    "archive/tar"
+    "context"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/st3iny/tarfs/internal/archive"
+    "github.com/st3iny/tarfs/internal/overlay"
+
+    gofs "github.com/hanwen/go-fuse/v2/fs"
+)
+
+func buildDirTar(t *testing.T, files map[string]string) string {
+    t.Helper()
+
+    file, err := os.CreateTemp("", "tarfs-rename-*.tar")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+
+    tw := tar.NewWriter(file)
+    mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    if err := tw.WriteHeader(&tar.Header{Name: "olddir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime}); err != nil {
+        t.Fatal(err)
+    }
+    for name, content := range files {
+        header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: mtime}
+        if err := tw.WriteHeader(header); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := tw.Write([]byte(content)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := tw.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    return file.Name()
+}
+
+func readTarContents(t *testing.T, path string) map[string]string {
+    t.Helper()
+
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+
+    contents := make(map[string]string)
+    reader := tar.NewReader(file)
+    for {
+        header, err := reader.Next()
+        if err != nil {
+            break
+        }
+        if header.Typeflag != tar.TypeReg {
+            continue
+        }
+        buf := make([]byte, header.Size)
+        if _, err := reader.Read(buf); err != nil && header.Size > 0 {
+            t.Fatalf("reading %q: %v", header.Name, err)
+        }
+        contents[header.Name] = string(buf)
+    }
+    return contents
+}
+
+// TestRenameDirThenWriteNestedFileCommitsUnderNewPath is the repro from the
+// review comment on File.Rename: renaming a directory and then writing a
+// file nested inside it used to key the overlay record under the file's
+// stale pre-rename path, so Commit emitted the new path with stale content
+// and a leftover entry at the old path with the edit.
+func TestRenameDirThenWriteNestedFileCommitsUnderNewPath(t *testing.T) {
+    archivePath := buildDirTar(t, map[string]string{"olddir/file.txt": "ORIGINAL"})
+    defer os.Remove(archivePath)
+
+    arch, err := archive.ReadArchive(archivePath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer arch.Close()
+
+    ov := overlay.New()
+    root := newRoot(arch, Options{}, ov)
+    gofs.NewNodeFS(root, nil)
+
+    ctx := context.Background()
+    if errno := root.Rename(ctx, "olddir", root, "newdir", 0); errno != 0 {
+        t.Fatalf("Rename: errno %d", errno)
+    }
+
+    newdir := root.Inode.GetChild("newdir")
+    if newdir == nil {
+        t.Fatal("newdir not found after rename")
+    }
+    child := newdir.GetChild("file.txt")
+    if child == nil {
+        t.Fatal("newdir/file.txt not found after rename")
+    }
+    childFile, ok := child.Operations().(*File)
+    if !ok {
+        t.Fatal("newdir/file.txt is not a *File")
+    }
+
+    if n, errno := childFile.Write(ctx, nil, []byte("WORLD"), 0); errno != 0 || n != 5 {
+        t.Fatalf("Write: n=%d errno=%d", n, errno)
+    }
+
+    outPath, err := os.CreateTemp("", "tarfs-commit-*.tar")
+    if err != nil {
+        t.Fatal(err)
+    }
+    outPath.Close()
+    defer os.Remove(outPath.Name())
+
+    if err := Commit(arch, ov, outPath.Name()); err != nil {
+        t.Fatal(err)
+    }
+
+    contents := readTarContents(t, outPath.Name())
+    if got, ok := contents["olddir/file.txt"]; ok {
+        t.Fatalf("leftover olddir/file.txt entry in committed tar: %q", got)
+    }
+    if got, ok := contents["newdir/file.txt"]; !ok || got != "WORLD" {
+        t.Fatalf("newdir/file.txt: got %q, ok=%v, want %q", got, ok, "WORLD")
+    }
+}
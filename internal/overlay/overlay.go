@@ -0,0 +1,295 @@
+// Package overlay implements a small in-memory copy-on-write layer that
+// internal/fs can stack on top of a read-only archive.Archive, turning
+// tarfs from a viewer into an editor without ever touching the archive
+// itself.
+package overlay
+
+import (
+    "strings"
+    "sync"
+)
+
+// record is one path's copy-on-write state. data is nil until the path is
+// first written or created, at which point reads and writes are served
+// entirely out of data instead of falling through to the lower archive.
+type record struct {
+    mu sync.Mutex
+    data []byte
+    dirty bool
+}
+
+// Overlay tracks every dirty file and every deleted/renamed path for one
+// mount. The zero value is not usable; use New.
+type Overlay struct {
+    mu sync.RWMutex
+    records map[string]*record
+    whiteouts map[string]bool
+
+    // renames maps a lower-layer path that was renamed while still
+    // pristine (so there's no overlay record to carry to the new path) to
+    // its new path, so Commit can relocate it without ever faulting its
+    // content in.
+    renames map[string]string
+}
+
+func New() *Overlay {
+    return &Overlay{
+        records: make(map[string]*record),
+        whiteouts: make(map[string]bool),
+        renames: make(map[string]string),
+    }
+}
+
+func (o *Overlay) recordFor(path string) *record {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    rec, ok := o.records[path]
+    if !ok {
+        rec = &record{}
+        o.records[path] = rec
+    }
+    delete(o.whiteouts, path)
+    return rec
+}
+
+func (o *Overlay) lookup(path string) (*record, bool) {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    rec, ok := o.records[path]
+    return rec, ok
+}
+
+// Create marks path as a brand-new, empty dirty file.
+func (o *Overlay) Create(path string) {
+    rec := o.recordFor(path)
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    rec.data = []byte{}
+    rec.dirty = true
+}
+
+// fault copies the lower layer's content into rec the first time path is
+// written to, so later writes only ever touch the in-memory copy.
+func (o *Overlay) fault(rec *record, lower func() ([]byte, error)) error {
+    if rec.dirty {
+        return nil
+    }
+    data, err := lower()
+    if err != nil {
+        return err
+    }
+    rec.data = data
+    rec.dirty = true
+    return nil
+}
+
+// WriteAt writes data into path's dirty copy, faulting in the lower
+// layer's content first if path hasn't been touched yet.
+func (o *Overlay) WriteAt(path string, data []byte, offset int64, lower func() ([]byte, error)) (int, error) {
+    rec := o.recordFor(path)
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+
+    if err := o.fault(rec, lower); err != nil {
+        return 0, err
+    }
+
+    end := offset + int64(len(data))
+    if end > int64(len(rec.data)) {
+        grown := make([]byte, end)
+        copy(grown, rec.data)
+        rec.data = grown
+    }
+    copy(rec.data[offset:], data)
+    return len(data), nil
+}
+
+// Truncate resizes path's dirty copy, faulting in the lower layer's content
+// first if needed.
+func (o *Overlay) Truncate(path string, size int64, lower func() ([]byte, error)) error {
+    rec := o.recordFor(path)
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+
+    if err := o.fault(rec, lower); err != nil {
+        return err
+    }
+
+    if size <= int64(len(rec.data)) {
+        rec.data = rec.data[:size]
+        return nil
+    }
+
+    grown := make([]byte, size)
+    copy(grown, rec.data)
+    rec.data = grown
+    return nil
+}
+
+// ReadAt serves a read out of path's dirty copy. ok is false when path has
+// no overlay record (or an untouched one) and the caller should read the
+// lower layer instead.
+func (o *Overlay) ReadAt(path string, dest []byte, offset int64) (n int, ok bool) {
+    rec, found := o.lookup(path)
+    if !found {
+        return 0, false
+    }
+
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    if !rec.dirty {
+        return 0, false
+    }
+    if offset >= int64(len(rec.data)) {
+        return 0, true
+    }
+    return copy(dest, rec.data[offset:]), true
+}
+
+// Size reports a dirty file's current size. ok is false if path isn't dirty.
+func (o *Overlay) Size(path string) (size int64, ok bool) {
+    rec, found := o.lookup(path)
+    if !found {
+        return 0, false
+    }
+
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    if !rec.dirty {
+        return 0, false
+    }
+    return int64(len(rec.data)), true
+}
+
+// Delete whites out path so it no longer appears in the merged view, even
+// though the lower archive still has it.
+func (o *Overlay) Delete(path string) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.whiteouts[path] = true
+    delete(o.records, path)
+}
+
+// IsDeleted reports whether path has been whited out.
+func (o *Overlay) IsDeleted(path string) bool {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    return o.whiteouts[path]
+}
+
+// DirtyPaths returns every path with a dirty (in-memory) record, for callers
+// that need to walk the overlay without going through a mounted File.
+func (o *Overlay) DirtyPaths() []string {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+
+    var paths []string
+    for path, rec := range o.records {
+        rec.mu.Lock()
+        dirty := rec.dirty
+        rec.mu.Unlock()
+        if dirty {
+            paths = append(paths, path)
+        }
+    }
+    return paths
+}
+
+// ReadAll returns a copy of a dirty path's full content. ok is false if path
+// isn't dirty.
+func (o *Overlay) ReadAll(path string) (data []byte, ok bool) {
+    rec, found := o.lookup(path)
+    if !found {
+        return nil, false
+    }
+
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    if !rec.dirty {
+        return nil, false
+    }
+
+    out := make([]byte, len(rec.data))
+    copy(out, rec.data)
+    return out, true
+}
+
+// Rename moves oldPath's overlay state (if any) to newPath and whites out
+// oldPath. If oldPath was never faulted into the overlay (the common case
+// for a plain mv of an untouched lower-layer entry), there's no record to
+// move, so the rename is recorded instead and Commit relocates the lower
+// entry itself without ever reading its content.
+//
+// oldPath may be a directory: any dirty record, whiteout or pristine-rename
+// already keyed under oldPath+"/..." (from edits or deletes made before this
+// rename) is rekeyed under the same newPath+"/..." prefix too, so a file
+// touched before its parent directory was renamed doesn't get left behind
+// under a path that no longer exists in the live tree. internal/fs also
+// updates every descendant File's effective path() at rename time (see
+// propagateRename), so a write *after* the rename keys its record under
+// newPath directly instead of landing here again.
+func (o *Overlay) Rename(oldPath string, newPath string) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    prefix := oldPath + "/"
+    var nestedRecords, nestedWhiteouts, nestedRenames []string
+    for key := range o.records {
+        if strings.HasPrefix(key, prefix) {
+            nestedRecords = append(nestedRecords, key)
+        }
+    }
+    for key := range o.whiteouts {
+        if strings.HasPrefix(key, prefix) {
+            nestedWhiteouts = append(nestedWhiteouts, key)
+        }
+    }
+    for key := range o.renames {
+        if strings.HasPrefix(key, prefix) {
+            nestedRenames = append(nestedRenames, key)
+        }
+    }
+
+    o.relocate(oldPath, newPath)
+    for _, key := range nestedRecords {
+        o.relocate(key, newPath+"/"+strings.TrimPrefix(key, prefix))
+    }
+    for _, key := range nestedWhiteouts {
+        delete(o.whiteouts, key)
+        o.whiteouts[newPath+"/"+strings.TrimPrefix(key, prefix)] = true
+    }
+    for _, key := range nestedRenames {
+        target := o.renames[key]
+        delete(o.renames, key)
+        o.renames[newPath+"/"+strings.TrimPrefix(key, prefix)] = target
+    }
+}
+
+// relocate moves a single path's dirty record (if any) from oldPath to
+// newPath, recording a pristine rename instead when there's nothing faulted
+// in yet, and whites out oldPath either way.
+func (o *Overlay) relocate(oldPath string, newPath string) {
+    if rec, ok := o.records[oldPath]; ok {
+        o.records[newPath] = rec
+        delete(o.records, oldPath)
+    } else {
+        o.renames[oldPath] = newPath
+    }
+    o.whiteouts[oldPath] = true
+    delete(o.whiteouts, newPath)
+}
+
+// Renames returns a copy of every still-pristine-path rename recorded, for
+// Commit to relocate a lower entry (and its subtree, if it's a directory)
+// to its new path.
+func (o *Overlay) Renames() map[string]string {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+
+    out := make(map[string]string, len(o.renames))
+    for oldPath, newPath := range o.renames {
+        out[oldPath] = newPath
+    }
+    return out
+}